@@ -38,24 +38,25 @@ import (
 	pb "github.com/ease-lab/vhive/examples/protobuf/helloworld"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	grpcstatus "google.golang.org/grpc/status"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
-
-	tracing "github.com/ease-lab/vhive/utils/tracing/go"
 )
 
 type Endpoint struct {
 	url      string
 	eventing bool
+	proto    protoKind
 }
 
 const TimeseriesDBAddr = "10.96.0.84:90"
 
 var (
-	completed   int64
-	latSlice    LatencySlice
-	portFlag    *int
-	withTracing *bool
+	completed int64
+	latSlice  RecordSlice
+	portFlag  *int
+	pool      *connPool
 )
 
 func main() {
@@ -64,8 +65,21 @@ func main() {
 	runDuration := flag.Int("time", 5, "Run the benchmark for X seconds")
 	latencyOutputFile := flag.String("latf", "lat.csv", "CSV file for the latency measurements in microseconds")
 	portFlag = flag.Int("port", 80, "The port that functions listen to")
-	withTracing = flag.Bool("trace", false, "Enable tracing in the client")
-	zipkin := flag.String("zipkin", "http://localhost:9411/api/v2/spans", "zipkin url")
+	withTracing := flag.Bool("trace", false, "Enable tracing in the client (shorthand for -otel-exporter=zipkin)")
+	zipkin := flag.String("zipkin", "http://localhost:9411/api/v2/spans", "zipkin url, used by -trace and by -otel-exporter=zipkin")
+	otelExporter := flag.String("otel-exporter", "none", "Tracing exporter: otlp|zipkin|jaeger|stdout|none")
+	otelEndpoint := flag.String("otel-endpoint", "", "Collector endpoint for the otlp/jaeger exporters")
+	otelInsecure := flag.Bool("otel-insecure", true, "Disable TLS when talking to the otel-endpoint")
+	otelHeaders := flag.String("otel-headers", "", "Comma-separated key=value headers sent with the otlp exporter")
+	otelSampleRatio := flag.Float64("otel-sample-ratio", 1, "Trace-id ratio sampler rate, parent-based; ignored unless set explicitly")
+	arrival := flag.String("arrival", "uniform", "Arrival process: uniform|poisson|closed")
+	concurrency := flag.Int("concurrency", 10, "Worker pool size for -arrival=closed")
+	maxInflight := flag.Int("max-inflight", 0, "Cap outstanding requests; 0 means unbounded")
+	connPerEndpoint := flag.Int("conn-per-endpoint", 1, "Number of gRPC subconns kept open per endpoint, round-robined")
+	keepaliveTime := flag.Duration("keepalive-time", 0, "Ping the peer if no activity for this long; 0 disables client keepalive pings")
+	keepaliveTimeout := flag.Duration("keepalive-timeout", 20*time.Second, "Time to wait for a keepalive ping ack before closing the connection")
+	keepalivePermitWithoutStream := flag.Bool("keepalive-permit-without-stream", false, "Send keepalive pings even when there are no in-flight calls")
+	reconnectEvery := flag.String("reconnect-every", "", "Force a fresh dial per subconn every <duration> or every <N> requests, to measure cold dial cost on demand")
 	debug := flag.Bool("dbg", false, "Enable debug logging")
 
 	flag.Parse()
@@ -89,19 +103,68 @@ func main() {
 		log.Fatal("Failed to read the URL files: ", err)
 	}
 
-	if *withTracing {
-		shutdown, err := tracing.InitBasicTracer(*zipkin, "invoker")
-		if err != nil {
-			log.Print(err)
-		}
-		defer shutdown()
+	reconnect, err := parseReconnectEvery(*reconnectEvery)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pool = newConnPool(*connPerEndpoint, reconnect, keepalive.ClientParameters{
+		Time:                *keepaliveTime,
+		Timeout:             *keepaliveTimeout,
+		PermitWithoutStream: *keepalivePermitWithoutStream,
+	}, grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()))
+
+	exporter := *otelExporter
+	endpoint := *otelEndpoint
+	if *withTracing && exporter == "none" {
+		// Preserve the old -trace behaviour: it used to always mean Zipkin.
+		exporter = "zipkin"
+	}
+	if exporter == "zipkin" && endpoint == "" {
+		endpoint = *zipkin
+	}
+
+	sampleSet := isFlagSet("otel-sample-ratio")
+	shutdown, err := initTracing(otelConfig{
+		exporter:    exporter,
+		endpoint:    endpoint,
+		insecure:    *otelInsecure,
+		headers:     *otelHeaders,
+		sampleRatio: *otelSampleRatio,
+		sampleSet:   sampleSet,
+		enabled:     *withTracing || exporter != "none",
+		targetRPS:   *rps,
+		urlFile:     *urlFile,
+	})
+	if err != nil {
+		log.Error("Failed to initialize tracing: ", err)
 	}
+	defer func() {
+		if err := shutdown(context.Background()); err != nil {
+			log.Warn("Failed to flush traces on shutdown: ", err)
+		}
+	}()
 
-	realRPS := runBenchmark(endpoints, *runDuration, *rps)
+	realRPS := runBenchmark(endpoints, *runDuration, *rps, arrivalMode(*arrival), *concurrency, *maxInflight)
 
 	writeLatencies(realRPS, *latencyOutputFile)
+	pool.logStats()
 }
 
+// isFlagSet reports whether the named flag was explicitly passed on the
+// command line, as opposed to only carrying its default value.
+func isFlagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// readEndpoints parses the urls file, one endpoint per line of the form
+// `<url>[\t<kind>[\t<proto>]]`, where kind is empty or "eventing" and proto
+// is one of grpc|http1|h2c|cloudevents (grpc is the default for backward
+// compatibility with two-column files).
 func readEndpoints(path string) (endpoints []Endpoint, _ error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -112,123 +175,105 @@ func readEndpoints(path string) (endpoints []Endpoint, _ error) {
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		tokens := strings.Split(scanner.Text(), "\t")
-		if len(tokens) == 0 {
-			endpoints = append(endpoints, Endpoint{
-				url:      tokens[0],
-				eventing: false,
-			})
-		} else if len(tokens) == 1 && tokens[1] == "eventing" {
-			endpoints = append(endpoints, Endpoint{
-				url:      tokens[0],
-				eventing: true,
-			})
-		} else {
-			log.Fatalf("malformed urls file: %+v", tokens)
-		}
-	}
-	return endpoints, scanner.Err()
-}
+		endpoint := Endpoint{url: tokens[0], proto: protoGRPC}
 
-func runBenchmark(endpoints []Endpoint, runDuration, targetRPS int) (realRPS float64) {
-	timeout := time.After(time.Duration(runDuration) * time.Second)
-	tick := time.Tick(time.Duration(1000/targetRPS) * time.Millisecond)
-
-	var issued int
-	start := time.Now()
-
-	for {
-		select {
-		case <-timeout:
-			duration := time.Since(start).Seconds()
-			realRPS = float64(completed) / duration
-			log.Infof("Issued / completed requests: %d, %d", issued, completed)
-			log.Infof("Real / target RPS: %.2f / %v", realRPS, targetRPS)
-
-			log.Println("Benchmark finished!")
-
-			return
-		case <-tick:
-			endpoint := endpoints[issued%len(endpoints)]
-			if endpoint.eventing {
-				go invokeEventingFunction(endpoint.url)
-			} else {
-				go invokeServingFunction(endpoint.url)
+		if len(tokens) >= 2 && tokens[1] != "" {
+			if tokens[1] != "eventing" {
+				log.Fatalf("malformed urls file: %+v", tokens)
 			}
+			endpoint.eventing = true
+		}
 
-			issued++
+		if len(tokens) >= 3 && tokens[2] != "" {
+			switch protoKind(tokens[2]) {
+			case protoGRPC, protoHTTP1, protoH2C, protoCloudEvents:
+				endpoint.proto = protoKind(tokens[2])
+			default:
+				log.Fatalf("malformed urls file, unknown proto: %+v", tokens)
+			}
 		}
+
+		endpoints = append(endpoints, endpoint)
 	}
+	return endpoints, scanner.Err()
 }
 
-func SayHello(address string) {
-	var dialOption grpc.DialOption
-	if *withTracing {
-		dialOption = grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor())
-	} else {
-		dialOption = grpc.WithBlock()
-	}
-	conn, err := grpc.Dial(address, grpc.WithInsecure(), dialOption)
+// sayHelloGRPC performs the actual gRPC hello call over a pooled, reused
+// connection. It is invoked from within the span that invoke already
+// opened, so the otelgrpc interceptor picks up the active span and
+// propagates it over W3C headers.
+func sayHelloGRPC(ctx context.Context, address string) (grpcStatus string, err error) {
+	conn, err := pool.get(ctx, address)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
-	defer conn.Close()
 
 	c := pb.NewGreeterClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
 	_, err = c.SayHello(ctx, &pb.HelloRequest{Name: "faas"})
-	if err != nil {
-		log.Warnf("Failed to invoke %v, err=%v", address, err)
+	if st, ok := grpcstatus.FromError(err); ok {
+		grpcStatus = st.Code().String()
 	}
+	return grpcStatus, err
 }
 
-func invokeEventingFunction(url string) {
-	address := fmt.Sprintf("%s:%d", url, *portFlag)
+func invokeEventingFunction(endpoint Endpoint, scheduled time.Time) {
+	address := fmt.Sprintf("%s:%d", endpoint.url, *portFlag)
 	log.Debug("Invoking by the address: %v", address)
 
+	dispatched := time.Now()
 	End := Start(TimeseriesDBAddr)
-	SayHello(address)
-	addDuration(End())
+	_ = invoke(context.Background(), endpoint, address, 0)
+	latency := End()
+
+	addRecord(record{
+		queueingUsec: dispatched.Sub(scheduled).Microseconds(),
+		latencyUsec:  latency.Microseconds(),
+		status:       "completed",
+	})
 
 	atomic.AddInt64(&completed, 1)
 
 	return
 }
 
-func invokeServingFunction(url string) {
-	defer getDuration(startMeasurement(url)) // measure entire invocation time
-
-	address := fmt.Sprintf("%s:%d", url, *portFlag)
+func invokeServingFunction(endpoint Endpoint, scheduled time.Time) {
+	dispatched := time.Now()
+	address := fmt.Sprintf("%s:%d", endpoint.url, *portFlag)
 	log.Debug("Invoking by the address: %v", address)
 
-	SayHello(address)
+	_ = invoke(context.Background(), endpoint, address, 0)
+
+	addRecord(record{
+		queueingUsec: dispatched.Sub(scheduled).Microseconds(),
+		latencyUsec:  time.Since(dispatched).Microseconds(),
+		status:       "completed",
+	})
 
 	atomic.AddInt64(&completed, 1)
 
 	return
 }
 
-// LatencySlice is a thread-safe slice to hold a slice of latency measurements.
-type LatencySlice struct {
-	sync.Mutex
-	slice []int64
-}
-
-func startMeasurement(msg string) (string, time.Time) {
-	return msg, time.Now()
+// record is a single row of the latency CSV: the time a request spent
+// queued inside the invoker before being dispatched (schedule -> dispatch),
+// the SUT-observed latency of the call itself (dispatch -> completion), and
+// its outcome.
+type record struct {
+	queueingUsec int64
+	latencyUsec  int64
+	status       string // completed | dropped | coalesced
 }
 
-func getDuration(msg string, start time.Time) {
-	latency := time.Since(start)
-	log.Debugf("Invoked %v in %v usec\n", msg, latency)
-	addDuration(latency)
+// RecordSlice is a thread-safe slice holding one record per scheduled request.
+type RecordSlice struct {
+	sync.Mutex
+	slice []record
 }
 
-func addDuration(d time.Duration) {
+func addRecord(r record) {
 	latSlice.Lock()
-	latSlice.slice = append(latSlice.slice, d.Microseconds())
+	latSlice.slice = append(latSlice.slice, r)
 	latSlice.Unlock()
 }
 
@@ -247,8 +292,9 @@ func writeLatencies(rps float64, latencyOutputFile string) {
 
 	datawriter := bufio.NewWriter(file)
 
-	for _, lat := range latSlice.slice {
-		_, err := datawriter.WriteString(strconv.FormatInt(lat, 10) + "\n")
+	for _, r := range latSlice.slice {
+		line := fmt.Sprintf("%d,%d,%s\n", r.queueingUsec, r.latencyUsec, r.status)
+		_, err := datawriter.WriteString(line)
 		if err != nil {
 			log.Fatal("Failed to write the URLs to a file ", err)
 		}
@@ -0,0 +1,64 @@
+// MIT License
+//
+// Copyright (c) 2020 Dmitrii Ustiugov and EASE lab
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadEndpoints(t *testing.T) {
+	content := "svc1.default.svc.cluster.local\n" +
+		"svc2.default.svc.cluster.local\teventing\n" +
+		"svc3.default.svc.cluster.local\t\thttp1\n" +
+		"svc4.default.svc.cluster.local\teventing\tcloudevents\n" +
+		"svc5.default.svc.cluster.local\t\th2c\n"
+
+	f, err := os.CreateTemp(t.TempDir(), "urls*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readEndpoints(f.Name())
+	if err != nil {
+		t.Fatalf("readEndpoints() error = %v", err)
+	}
+
+	want := []Endpoint{
+		{url: "svc1.default.svc.cluster.local", proto: protoGRPC},
+		{url: "svc2.default.svc.cluster.local", eventing: true, proto: protoGRPC},
+		{url: "svc3.default.svc.cluster.local", proto: protoHTTP1},
+		{url: "svc4.default.svc.cluster.local", eventing: true, proto: protoCloudEvents},
+		{url: "svc5.default.svc.cluster.local", proto: protoH2C},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readEndpoints() = %+v, want %+v", got, want)
+	}
+}
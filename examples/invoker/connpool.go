@@ -0,0 +1,235 @@
+// MIT License
+//
+// Copyright (c) 2020 Dmitrii Ustiugov and EASE lab
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/stats"
+)
+
+// defaultServiceConfig enables grpc-go's built-in retry/backoff for
+// transient unary-call failures, so a flaky reconnect doesn't automatically
+// count as a failed invocation.
+const defaultServiceConfig = `{
+	"methodConfig": [{
+		"name": [{}],
+		"retryPolicy": {
+			"MaxAttempts": 3,
+			"InitialBackoff": "0.1s",
+			"MaxBackoff": "1s",
+			"BackoffMultiplier": 2.0,
+			"RetryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+
+// connStats is a grpc stats.Handler that feeds the connection teardowns it
+// sees back into the subConn's cumulative counters. A teardown after the
+// connection was already established is our proxy for "received a GOAWAY",
+// since the gRPC stats API does not expose HTTP/2 frames directly. It
+// points at the owning subConn's fields rather than holding its own, so a
+// forced reconnect (--reconnect-every) dials a fresh connStats but keeps
+// accumulating into the same counters instead of resetting them.
+type connStats struct {
+	streamsIssued *int64
+	goAways       *int64
+}
+
+func (s *connStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context { return ctx }
+func (s *connStats) HandleRPC(context.Context, stats.RPCStats)                       {}
+func (s *connStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+func (s *connStats) HandleConn(_ context.Context, cs stats.ConnStats) {
+	if _, ok := cs.(*stats.ConnEnd); ok {
+		atomic.AddInt64(s.goAways, 1)
+	}
+}
+
+// reconnectPolicy controls when connPool forces a fresh dial to measure cold
+// connection cost on demand, either on a wall-clock period or after a fixed
+// number of requests.
+type reconnectPolicy struct {
+	every  time.Duration
+	everyN int64
+}
+
+// parseReconnectEvery parses --reconnect-every, accepting either a
+// time.Duration string (e.g. "30s") or a bare request count (e.g. "500").
+// An empty string disables forced reconnects.
+func parseReconnectEvery(s string) (reconnectPolicy, error) {
+	if s == "" {
+		return reconnectPolicy{}, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return reconnectPolicy{everyN: n}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return reconnectPolicy{}, fmt.Errorf("--reconnect-every must be a duration or a request count, got %q: %w", s, err)
+	}
+	return reconnectPolicy{every: d}, nil
+}
+
+// subConn is one of the N round-robin connections connPool keeps open to a
+// given address. streamsIssued and goAways are cumulative across the
+// lifetime of the subConn slot, surviving any number of forced reconnects.
+type subConn struct {
+	mu        sync.Mutex
+	conn      *grpc.ClientConn
+	dialedAt  time.Time
+	sinceDial int64
+
+	streamsIssued int64
+	goAways       int64
+}
+
+// endpointConns is the fixed-size pool of subconns dialed to one address,
+// plus the round-robin cursor used to pick the next one.
+type endpointConns struct {
+	subConns []*subConn
+	cursor   int64
+}
+
+// connPool reuses *grpc.ClientConn across calls, keyed by address, with
+// connsPerEndpoint subconns per address round-robined to defeat HTTP/2
+// single-connection head-of-line blocking.
+type connPool struct {
+	mu               sync.Mutex
+	entries          map[string]*endpointConns
+	connsPerEndpoint int
+	reconnect        reconnectPolicy
+	dialOpts         []grpc.DialOption
+}
+
+func newConnPool(connsPerEndpoint int, reconnect reconnectPolicy, keepaliveParams keepalive.ClientParameters, extraDialOpts ...grpc.DialOption) *connPool {
+	if connsPerEndpoint < 1 {
+		connsPerEndpoint = 1
+	}
+	dialOpts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithDefaultServiceConfig(defaultServiceConfig),
+	}
+	if keepaliveParams.Time > 0 {
+		// grpc-go silently clamps Time up to its ~10s internal minimum, so a
+		// literal Time: 0 would NOT disable pings as the flag help text
+		// promises - it would just start sending them every 10s. Only add
+		// the option when the caller actually asked for keepalive pings.
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(keepaliveParams))
+	}
+	return &connPool{
+		entries:          make(map[string]*endpointConns),
+		connsPerEndpoint: connsPerEndpoint,
+		reconnect:        reconnect,
+		dialOpts:         append(dialOpts, extraDialOpts...),
+	}
+}
+
+// get returns a *grpc.ClientConn for address, round-robining across
+// connsPerEndpoint subconns and forcing a reconnect when the active policy
+// says the current one is due for replacement.
+func (p *connPool) get(ctx context.Context, address string) (*grpc.ClientConn, error) {
+	sc := p.pick(address)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.conn != nil && p.dueForReconnect(sc) {
+		sc.conn.Close()
+		sc.conn = nil
+	}
+
+	if sc.conn == nil {
+		st := &connStats{streamsIssued: &sc.streamsIssued, goAways: &sc.goAways}
+		opts := append(append([]grpc.DialOption{}, p.dialOpts...), grpc.WithStatsHandler(st))
+		conn, err := grpc.DialContext(ctx, address, opts...)
+		if err != nil {
+			return nil, err
+		}
+		sc.conn = conn
+		sc.dialedAt = time.Now()
+		sc.sinceDial = 0
+	}
+
+	sc.sinceDial++
+	atomic.AddInt64(&sc.streamsIssued, 1)
+	return sc.conn, nil
+}
+
+func (p *connPool) dueForReconnect(sc *subConn) bool {
+	if p.reconnect.every > 0 && time.Since(sc.dialedAt) >= p.reconnect.every {
+		return true
+	}
+	if p.reconnect.everyN > 0 && sc.sinceDial >= p.reconnect.everyN {
+		return true
+	}
+	return false
+}
+
+// pick returns the next subconn for address in round-robin order, lazily
+// creating the connsPerEndpoint-sized slot the first time address is seen.
+func (p *connPool) pick(address string) *subConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ec, ok := p.entries[address]
+	if !ok {
+		ec = &endpointConns{subConns: make([]*subConn, p.connsPerEndpoint)}
+		for i := range ec.subConns {
+			ec.subConns[i] = &subConn{}
+		}
+		p.entries[address] = ec
+	}
+
+	sc := ec.subConns[ec.cursor%int64(len(ec.subConns))]
+	ec.cursor++
+	return sc
+}
+
+// logStats prints per-connection counters once the benchmark is done, so
+// users can tell a genuinely idle run from one that silently reconnected.
+func (p *connPool) logStats() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for address, ec := range p.entries {
+		for i, sc := range ec.subConns {
+			sc.mu.Lock()
+			if sc.conn != nil || atomic.LoadInt64(&sc.streamsIssued) > 0 {
+				log.Infof("conn %s#%d: streams=%d goaways=%d", address, i,
+					atomic.LoadInt64(&sc.streamsIssued), atomic.LoadInt64(&sc.goAways))
+			}
+			sc.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// MIT License
+//
+// Copyright (c) 2020 Dmitrii Ustiugov and EASE lab
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseReconnectEvery(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    reconnectPolicy
+		wantErr bool
+	}{
+		{"empty disables", "", reconnectPolicy{}, false},
+		{"request count", "500", reconnectPolicy{everyN: 500}, false},
+		{"duration", "30s", reconnectPolicy{every: 30 * time.Second}, false},
+		{"garbage", "not-a-thing", reconnectPolicy{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseReconnectEvery(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReconnectEvery(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseReconnectEvery(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnPoolPickRoundRobins(t *testing.T) {
+	p := &connPool{
+		entries:          make(map[string]*endpointConns),
+		connsPerEndpoint: 3,
+	}
+
+	first := p.pick("addr")
+	second := p.pick("addr")
+	third := p.pick("addr")
+	fourth := p.pick("addr")
+
+	if first == second || second == third || first == third {
+		t.Fatalf("pick() returned the same subConn twice within one round: %p %p %p", first, second, third)
+	}
+	if fourth != first {
+		t.Errorf("pick() on the 4th call = %p, want it to wrap back to the 1st subConn %p", fourth, first)
+	}
+}
+
+func TestConnPoolDueForReconnect(t *testing.T) {
+	p := &connPool{reconnect: reconnectPolicy{everyN: 3}}
+
+	sc := &subConn{dialedAt: time.Now()}
+	sc.sinceDial = 2
+	if p.dueForReconnect(sc) {
+		t.Errorf("dueForReconnect() = true before reaching everyN, want false")
+	}
+	sc.sinceDial = 3
+	if !p.dueForReconnect(sc) {
+		t.Errorf("dueForReconnect() = false at everyN, want true")
+	}
+
+	p = &connPool{reconnect: reconnectPolicy{every: 10 * time.Millisecond}}
+	sc = &subConn{dialedAt: time.Now().Add(-time.Hour)}
+	if !p.dueForReconnect(sc) {
+		t.Errorf("dueForReconnect() = false once the wall-clock period elapsed, want true")
+	}
+
+	p = &connPool{}
+	sc = &subConn{dialedAt: time.Now().Add(-time.Hour), sinceDial: 1000000}
+	if p.dueForReconnect(sc) {
+		t.Errorf("dueForReconnect() = true with no reconnect policy set, want false")
+	}
+}
@@ -0,0 +1,192 @@
+// MIT License
+//
+// Copyright (c) 2020 Dmitrii Ustiugov and EASE lab
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// arrivalKind selects the process that schedules request arrivals.
+type arrivalKind string
+
+const (
+	arrivalUniform arrivalKind = "uniform"
+	arrivalPoisson arrivalKind = "poisson"
+	arrivalClosed  arrivalKind = "closed"
+)
+
+func arrivalMode(s string) arrivalKind {
+	switch arrivalKind(s) {
+	case arrivalPoisson:
+		return arrivalPoisson
+	case arrivalClosed:
+		return arrivalClosed
+	default:
+		return arrivalUniform
+	}
+}
+
+var (
+	issued    int64
+	dropped   int64
+	coalesced int64
+)
+
+// runBenchmark drives the invocation schedule for runDuration seconds.
+// uniform and poisson are open-loop: arrivals are scheduled independently of
+// completions and an inflight cap (maxInflight, 0 = unbounded) sheds load by
+// recording a "dropped" row instead of blocking the scheduler. closed is a
+// bounded worker pool of size concurrency that reissues as soon as its
+// previous request completes.
+func runBenchmark(endpoints []Endpoint, runDuration int, targetRPS int, mode arrivalKind, concurrency, maxInflight int) (realRPS float64) {
+	start := time.Now()
+	deadline := start.Add(time.Duration(runDuration) * time.Second)
+
+	var inflight chan struct{}
+	if maxInflight > 0 {
+		inflight = make(chan struct{}, maxInflight)
+	}
+
+	var wg sync.WaitGroup
+	dispatch := func(endpoint Endpoint, scheduled time.Time) {
+		if inflight != nil {
+			select {
+			case inflight <- struct{}{}:
+			default:
+				atomic.AddInt64(&dropped, 1)
+				addRecord(record{status: "dropped"})
+				return
+			}
+		}
+
+		atomic.AddInt64(&issued, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if inflight != nil {
+				defer func() { <-inflight }()
+			}
+			if endpoint.eventing {
+				invokeEventingFunction(endpoint, scheduled)
+			} else {
+				invokeServingFunction(endpoint, scheduled)
+			}
+		}()
+	}
+
+	switch mode {
+	case arrivalClosed:
+		runClosedLoop(endpoints, deadline, concurrency)
+	default:
+		runOpenLoop(endpoints, deadline, targetRPS, mode, dispatch)
+		// Wait for in-flight dispatches to finish and record their rows
+		// before the caller writes the CSV, even if the deadline hit while
+		// requests were still outstanding (the overload case this arrival
+		// mode exists to characterize).
+		wg.Wait()
+	}
+
+	duration := time.Since(start).Seconds()
+	realRPS = float64(atomic.LoadInt64(&completed)) / duration
+	log.Infof("Issued / completed / dropped / coalesced requests: %d / %d / %d / %d",
+		atomic.LoadInt64(&issued), completed, atomic.LoadInt64(&dropped), atomic.LoadInt64(&coalesced))
+	log.Infof("Real / target RPS: %.2f / %v", realRPS, targetRPS)
+	log.Println("Benchmark finished!")
+
+	return
+}
+
+// runOpenLoop schedules arrivals on their own clock, independent of how long
+// each dispatched request takes to complete. Ticks that fall behind because
+// the goroutine driving the loop was descheduled are coalesced into a single
+// catch-up dispatch rather than bursting all of them at once.
+func runOpenLoop(endpoints []Endpoint, deadline time.Time, targetRPS int, mode arrivalKind, dispatch func(Endpoint, time.Time)) {
+	rate := float64(targetRPS)
+	interval := time.Duration(1000.0/rate) * time.Millisecond
+
+	var n int
+	next := time.Now()
+
+	for {
+		if !next.Before(deadline) {
+			return
+		}
+
+		sleep := time.Until(next)
+		if sleep > 0 {
+			time.Sleep(sleep)
+		} else if interval > 0 && -sleep > interval {
+			// We fell more than one interval behind; coalesce the missed
+			// arrivals into this one catch-up dispatch instead of bursting
+			// them all at once, recording a "coalesced" row for each.
+			missed := int64(-sleep / interval)
+			atomic.AddInt64(&coalesced, missed)
+			for i := int64(0); i < missed; i++ {
+				addRecord(record{status: "coalesced"})
+			}
+			next = next.Add(time.Duration(missed) * interval)
+		}
+
+		endpoint := endpoints[n%len(endpoints)]
+		dispatch(endpoint, next)
+		n++
+
+		if mode == arrivalPoisson {
+			next = next.Add(time.Duration(rand.ExpFloat64() / rate * float64(time.Second)))
+		} else {
+			next = next.Add(interval)
+		}
+	}
+}
+
+// runClosedLoop keeps `concurrency` workers permanently in flight: as soon as
+// a worker's request completes it immediately issues the next one.
+func runClosedLoop(endpoints []Endpoint, deadline time.Time, concurrency int) {
+	var wg sync.WaitGroup
+	var n int64
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				i := atomic.AddInt64(&n, 1) - 1
+				endpoint := endpoints[i%int64(len(endpoints))]
+				atomic.AddInt64(&issued, 1)
+				scheduled := time.Now()
+				if endpoint.eventing {
+					invokeEventingFunction(endpoint, scheduled)
+				} else {
+					invokeServingFunction(endpoint, scheduled)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,74 @@
+// MIT License
+//
+// Copyright (c) 2020 Dmitrii Ustiugov and EASE lab
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArrivalMode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want arrivalKind
+	}{
+		{"uniform", arrivalUniform},
+		{"poisson", arrivalPoisson},
+		{"closed", arrivalClosed},
+		{"", arrivalUniform},
+		{"bogus", arrivalUniform},
+	}
+	for _, tt := range tests {
+		if got := arrivalMode(tt.in); got != tt.want {
+			t.Errorf("arrivalMode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestRunOpenLoopUniformInterval checks that the uniform arrival process
+// dispatches at the configured rate and stops once the deadline passes. It
+// compares the scheduled times handed to dispatch rather than measured wall
+// clock, and uses a short window to keep the run fast.
+func TestRunOpenLoopUniformInterval(t *testing.T) {
+	endpoints := []Endpoint{{url: "dummy"}}
+
+	var scheduledAt []time.Time
+	dispatch := func(_ Endpoint, scheduled time.Time) {
+		scheduledAt = append(scheduledAt, scheduled)
+	}
+
+	targetRPS := 100
+	deadline := time.Now().Add(50 * time.Millisecond)
+	runOpenLoop(endpoints, deadline, targetRPS, arrivalUniform, dispatch)
+
+	if len(scheduledAt) < 2 {
+		t.Fatalf("got %d dispatches, want at least 2", len(scheduledAt))
+	}
+
+	wantInterval := time.Duration(1000.0/float64(targetRPS)) * time.Millisecond
+	for i := 1; i < len(scheduledAt); i++ {
+		if got := scheduledAt[i].Sub(scheduledAt[i-1]); got != wantInterval {
+			t.Errorf("interval between dispatch %d and %d = %v, want %v", i-1, i, got, wantInterval)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// MIT License
+//
+// Copyright (c) 2020 Dmitrii Ustiugov and EASE lab
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// otelConfig collects the flags that shape the invoker's tracing pipeline.
+type otelConfig struct {
+	exporter    string // otlp | zipkin | jaeger | stdout | none
+	endpoint    string
+	insecure    bool
+	headers     string // comma-separated key=value pairs
+	sampleRatio float64
+	sampleSet   bool // whether --otel-sample-ratio was explicitly provided
+	enabled     bool // true when --trace or a non-"none" --otel-exporter was given
+
+	// run metadata surfaced as resource attributes
+	targetRPS int
+	urlFile   string
+}
+
+var tracer trace.Tracer
+
+// initTracing wires up a TracerProvider for the selected exporter, installs it
+// as the global provider and sets the W3C trace-context + baggage propagator
+// so spans flow into the invoked Knative functions. The returned shutdown
+// func must be called once the benchmark is done to flush pending spans.
+func initTracing(cfg otelConfig) (func(context.Context) error, error) {
+	if !cfg.enabled || cfg.exporter == "none" {
+		return disableTracing(), nil
+	}
+
+	exp, err := newExporter(cfg)
+	if err != nil {
+		// Fall back to a disabled (but non-nil) tracer so invoke() never
+		// starts a span on a nil trace.Tracer; the caller still learns about
+		// the bad config via the returned error.
+		return disableTracing(), fmt.Errorf("failed to build %s exporter: %w", cfg.exporter, err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(
+			semconv.ServiceNameKey.String("invoker"),
+			semconv.ServiceVersionKey.String("devel"),
+			attribute.Int("vhive.invoker.target_rps", cfg.targetRPS),
+			attribute.String("vhive.invoker.url_file", cfg.urlFile),
+		),
+	)
+	if err != nil {
+		return disableTracing(), fmt.Errorf("failed to merge resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(newSampler(cfg)),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(newPropagator())
+	tracer = tp.Tracer("invoker")
+
+	return tp.Shutdown, nil
+}
+
+// disableTracing installs a NeverSample provider so the otelgrpc interceptor
+// and invoke() stay cheap but always have a non-nil tracer and sane
+// propagation headers to work with. Used both when tracing was never
+// requested and as the fallback when a requested exporter fails to build.
+func disableTracing() func(context.Context) error {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample()))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(newPropagator())
+	tracer = tp.Tracer("invoker")
+	return tp.Shutdown
+}
+
+// newSampler defaults to AlwaysSample when tracing is enabled but no ratio
+// was requested, and to a parent-based trace-id ratio sampler otherwise.
+func newSampler(cfg otelConfig) sdktrace.Sampler {
+	if !cfg.sampleSet {
+		return sdktrace.AlwaysSample()
+	}
+	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.sampleRatio))
+}
+
+func newPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+}
+
+func newExporter(cfg otelConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.exporter {
+	case "otlp":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.endpoint)}
+		if cfg.insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if headers := parseHeaders(cfg.headers); len(headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(headers))
+		}
+		return otlptracegrpc.New(context.Background(), opts...)
+	case "zipkin":
+		return zipkin.New(cfg.endpoint)
+	case "jaeger":
+		return jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(cfg.endpoint)))
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown otel-exporter %q", cfg.exporter)
+	}
+}
+
+func parseHeaders(raw string) map[string]string {
+	headers := make(map[string]string)
+	for _, kv := range strings.Split(raw, ",") {
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			log.Warnf("ignoring malformed --otel-headers entry: %q", kv)
+			continue
+		}
+		headers[parts[0]] = parts[1]
+	}
+	return headers
+}
+
+// endpointKind returns the span attribute value describing whether an
+// endpoint is invoked through the serving or the eventing path.
+func endpointKind(eventing bool) string {
+	if eventing {
+		return "eventing"
+	}
+	return "serving"
+}
@@ -0,0 +1,66 @@
+// MIT License
+//
+// Copyright (c) 2020 Dmitrii Ustiugov and EASE lab
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"single pair", "k=v", map[string]string{"k": "v"}},
+		{"multiple pairs", "a=1,b=2", map[string]string{"a": "1", "b": "2"}},
+		{"value containing equals", "auth=Bearer tok=en", map[string]string{"auth": "Bearer tok=en"}},
+		{"malformed entry ignored", "a=1,bogus,c=3", map[string]string{"a": "1", "c": "3"}},
+		{"empty entries ignored", "a=1,,b=2", map[string]string{"a": "1", "b": "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseHeaders(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeaders(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSampler(t *testing.T) {
+	if _, ok := newSampler(otelConfig{sampleSet: false}).(interface{ Description() string }); !ok {
+		t.Fatalf("newSampler() returned a type without Description(), want a sdktrace.Sampler")
+	}
+	if got := newSampler(otelConfig{sampleSet: false}).Description(); got != sdktrace.AlwaysSample().Description() {
+		t.Errorf("newSampler() without sampleSet = %q, want AlwaysSample", got)
+	}
+
+	wantRatio := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(0.5)).Description()
+	if got := newSampler(otelConfig{sampleSet: true, sampleRatio: 0.5}).Description(); got != wantRatio {
+		t.Errorf("newSampler() with sampleSet = %q, want %q", got, wantRatio)
+	}
+}
@@ -0,0 +1,176 @@
+// MIT License
+//
+// Copyright (c) 2020 Dmitrii Ustiugov and EASE lab
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+)
+
+// protoKind is the wire protocol used to reach an endpoint, the third column
+// of the urls file.
+type protoKind string
+
+const (
+	protoGRPC        protoKind = "grpc"
+	protoHTTP1       protoKind = "http1"
+	protoH2C         protoKind = "h2c"
+	protoCloudEvents protoKind = "cloudevents"
+)
+
+// h2cClient talks HTTP/2 cleartext: AllowHTTP plus a plain-TCP DialTLS let us
+// benchmark h2c functions without a TLS terminator in front of them.
+var h2cClient = &http.Client{
+	Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	},
+}
+
+var http1Client = &http.Client{}
+
+// callTimeout bounds every outbound invocation, HTTP and gRPC alike, so a
+// hung target can't block the dispatching goroutine indefinitely - which
+// would in turn stall the wg.Wait() runBenchmark uses to drain in-flight
+// requests before writing the CSV.
+const callTimeout = 30 * time.Second
+
+// invoke dispatches a single request to address over endpoint's configured
+// protocol, wrapping the call in its own span so tracing, latency accounting
+// and the completed counter behave uniformly across gRPC, HTTP/1.1, h2c and
+// CloudEvents targets.
+func invoke(ctx context.Context, endpoint Endpoint, address string, attempt int) error {
+	ctx, span := tracer.Start(ctx, "invoke")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	span.SetAttributes(
+		attribute.String("url", address),
+		attribute.String("endpoint.kind", endpointKind(endpoint.eventing)),
+		attribute.String("endpoint.proto", string(endpoint.proto)),
+		attribute.Int("attempt", attempt),
+	)
+
+	var err error
+	switch endpoint.proto {
+	case protoHTTP1:
+		err = invokeHTTP(ctx, http1Client, address, span)
+	case protoH2C:
+		err = invokeHTTP(ctx, h2cClient, address, span)
+	case protoCloudEvents:
+		err = invokeCloudEvent(ctx, address, span)
+	default:
+		var grpcStatus string
+		grpcStatus, err = sayHelloGRPC(ctx, address)
+		if grpcStatus != "" {
+			span.SetAttributes(attribute.String("rpc.grpc.status_code", grpcStatus))
+		}
+	}
+
+	if err != nil {
+		span.SetStatus(otelcodes.Error, err.Error())
+		log.Warnf("Failed to invoke %v (%s), err=%v", address, endpoint.proto, err)
+	}
+	return err
+}
+
+// invokeHTTP issues a plain GET over the given client, which is either the
+// stdlib HTTP/1.1 transport or the h2c transport configured above. The W3C
+// trace-context and baggage headers are injected from the active span so
+// the callee can continue the trace.
+func invokeHTTP(ctx context.Context, client *http.Client, address string, span trace.Span) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s", address), nil)
+	if err != nil {
+		return err
+	}
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	propagation.Baggage{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(resp)
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("http request to %s failed with status %s", address, resp.Status)
+	}
+	return nil
+}
+
+// invokeCloudEvent POSTs a CloudEvents 1.0 binary-mode HTTP request so the
+// eventing path can exercise a real Knative Eventing broker instead of the
+// gRPC hello call.
+func invokeCloudEvent(ctx context.Context, address string, span trace.Span) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s", address), bytes.NewReader([]byte(`{"name":"faas"}`)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-type", "invoker.benchmark.hello")
+	req.Header.Set("ce-source", "vhive/invoker")
+	req.Header.Set("ce-id", fmt.Sprintf("%d", time.Now().UnixNano()))
+	req.Header.Set("Content-Type", "application/json")
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	propagation.Baggage{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := http1Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(resp)
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("cloudevent request to %s failed with status %s", address, resp.Status)
+	}
+	return nil
+}
+
+// drainAndClose reads the response body to EOF before closing it. Closing
+// without draining makes net/http abandon the underlying connection instead
+// of returning it to the pool, so every invocation pays a fresh dial/handshake
+// cost instead of reusing one - the same "misleading latency" problem the
+// gRPC connPool works around.
+func drainAndClose(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}